@@ -4,110 +4,612 @@
 package peer
 
 import (
-	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"sync"
 )
 
-type Handler func(params interface{})
+const jsonrpcVersion = "2.0"
 
+// DefaultCancelMethod is the notification method used to signal that a
+// previously sent request should be canceled, following the convention used
+// by the Language Server Protocol. Override it via Peer.CancelMethod.
+const DefaultCancelMethod = "$/cancelRequest"
+
+// ErrShutdown is returned by Call/CallSync/CallContext once the peer has
+// been closed or its stream has ended. It is also set as the Error of every
+// call still pending when Close is called; calls still pending when the
+// stream ends on its own fail with the read error instead (e.g. io.EOF).
+var ErrShutdown = errors.New("connection is shut down")
+
+// ErrSubscriptionClosed is set as a Subscription's underlying Call.Error
+// once Subscription.Close is called.
+var ErrSubscriptionClosed = errors.New("subscription closed")
+
+// Standard JSON-RPC 2.0 error codes, as defined by the spec.
+const (
+	ErrCodeParseError     = -32700
+	ErrCodeInvalidRequest = -32600
+	ErrCodeMethodNotFound = -32601
+	ErrCodeInvalidParams  = -32602
+	ErrCodeInternalError  = -32603
+
+	// ErrCodeServerOverloaded is returned for a request rejected outright
+	// because Peer.Capacity in-flight requests were already being handled
+	// and Peer.RejectIfOverloaded is set.
+	ErrCodeServerOverloaded = -32000
+)
+
+// RPCError is a JSON-RPC 2.0 error object. It is set on Call.Error when a
+// call the peer sent comes back with an "error" member, and may be returned
+// by a Handler to control the error sent back to the remote peer.
+type RPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return e.Message
+}
+
+// Handler processes an inbound request or notification. Params holds the
+// raw, still-encoded "params" member. If the inbound message was a request
+// (it carried an "id"), the returned result and error are encoded into the
+// JSON-RPC response written back to the peer; for notifications, the return
+// values are ignored. ctx is canceled if the peer sends a cancel
+// notification for this request's id before the handler returns.
+type Handler func(ctx context.Context, params json.RawMessage) (result interface{}, err error)
+
+// handlerEntry is what's actually stored per method: the handler plus the
+// dispatch mode Handle's options configured for it.
+type handlerEntry struct {
+	fn     Handler
+	serial bool
+}
+
+// HandleOption configures how Handle dispatches requests for a method.
+type HandleOption func(*handlerEntry)
+
+// HandleSerial makes requests for this method run one at a time, in the
+// order they were received, waiting for the previous one to finish
+// replying before the next one starts. Use it for methods where the peer
+// relies on ordering, e.g. Xi's per-view "update" notifications.
+func HandleSerial() HandleOption {
+	return func(e *handlerEntry) { e.serial = true }
+}
+
+// HandleParallel makes requests for this method run as soon as every
+// earlier serial request has finished, with no ordering guarantee relative
+// to one another. This is the default.
+func HandleParallel() HandleOption {
+	return func(e *handlerEntry) { e.serial = false }
+}
+
+// Call represents an active RPC, modeled after net/rpc.Call.
 type Call struct {
-	ID     uint64      `json:"id,omitempty"`
-	Method string      `json:"method"`
-	Params interface{} `json:"params"`
-	Reply  interface{} `json:"-"`
-	Done   chan *Call  `json:"-"`
-	Error  error       `json:"-"`
+	ID     uint64
+	Method string
+	Params interface{}
+	Reply  interface{}
+	Done   chan *Call
+	Error  error
+
+	// stream and frames are set by Subscribe to mark this Call as a
+	// subscription: the frame's raw result is delivered here instead of
+	// completing the call after a single response. See handleStreamFrame
+	// and deliverFrames.
+	stream chan json.RawMessage
+	frames *frameQueue
+}
+
+// message is the wire representation of a JSON-RPC 2.0 request, response, or
+// notification. The same struct is used in both directions, with the set of
+// populated members determined by the message kind rather than direction.
+type message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
 }
 
-type incoming struct {
-	ID     uint64           `json:"id"`
-	Method string           `json:"method"`
-	Params interface{}      `json:"params"`
-	Result *json.RawMessage `json:"result"`
-	// errors are currently not to be expected from Xi
-	// Error  interface{}      `json:"error"`
+func (m *message) isRequest() bool {
+	return m.Method != "" && len(m.ID) > 0
+}
+
+func (m *message) isNotification() bool {
+	return m.Method != "" && len(m.ID) == 0
+}
+
+func (m *message) isResponse() bool {
+	return m.Method == ""
 }
 
 type Peer struct {
-	in  io.Reader
-	out *json.Encoder
+	stream Stream
+
+	// CancelMethod is the notification method used to cancel requests, both
+	// sent (via CallContext) and received (via the ctx passed to Handler).
+	// Defaults to DefaultCancelMethod when empty.
+	CancelMethod string
+
+	// Capacity bounds the number of inbound requests allowed to be in
+	// flight at once. Zero (the default) means unbounded.
+	Capacity int
+	// RejectIfOverloaded, if true, makes the peer reply to a request that
+	// would exceed Capacity with an ErrCodeServerOverloaded error instead
+	// of blocking the reader until a slot frees up.
+	RejectIfOverloaded bool
 
-	reqMutex sync.Mutex
+	writeMutex sync.Mutex // protects stream writes
 
 	handlerMutex sync.Mutex // protects following
-	handler      map[string]Handler
+	handler      map[string]handlerEntry
+
+	inflightMutex sync.Mutex // protects following
+	inflight      map[string]context.CancelFunc
+
+	dispatchMutex sync.Mutex // protects following
+	nextRequest   chan struct{}
+
+	semOnce sync.Once
+	sem     chan struct{} // capacity semaphore, lazily sized to Capacity
 
-	mutex   sync.Mutex // protects following
-	seq     uint64
-	pending map[uint64]*Call
+	mutex    sync.Mutex // protects following
+	seq      uint64
+	pending  map[uint64]*Call
+	closing  bool // set by Close
+	shutdown bool // set once the stream has ended
 }
 
+// New creates a Peer that exchanges newline-delimited JSON messages over
+// stdin/stdout, the setup used to talk to a Xi plugin's child process.
 func New() *Peer {
+	return NewWithStream(NewNewlineStream(os.Stdin, os.Stdout))
+}
+
+// NewWithStream creates a Peer that exchanges JSON-RPC 2.0 messages over
+// stream.
+func NewWithStream(stream Stream) *Peer {
 	peer := &Peer{
-		in:      os.Stdin,
-		out:     json.NewEncoder(os.Stdout),
-		handler: make(map[string]Handler),
-		pending: make(map[uint64]*Call),
+		stream:      stream,
+		handler:     make(map[string]handlerEntry),
+		inflight:    make(map[string]context.CancelFunc),
+		nextRequest: closedChan(),
+		pending:     make(map[uint64]*Call),
 	}
 	go peer.run()
 	return peer
 }
 
+func closedChan() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}
+
+func (p *Peer) cancelMethod() string {
+	if p.CancelMethod != "" {
+		return p.CancelMethod
+	}
+	return DefaultCancelMethod
+}
+
 func (p *Peer) run() {
-	sc := bufio.NewScanner(p.in)
-	for sc.Scan() {
-		inc := new(incoming)
-		if err := json.Unmarshal(sc.Bytes(), inc); err != nil {
-			log.Fatal(err)
-		}
-
-		if inc.Result != nil { // received a response
-			p.mutex.Lock()
-			call := p.pending[inc.ID]
-			delete(p.pending, inc.ID)
-			p.mutex.Unlock()
-
-			if call == nil {
-				log.Println("rpc: dropping response that does not have a corresponding pending request")
-				continue
-			}
+	ctx := context.Background()
+	for {
+		data, err := p.stream.Read(ctx)
+		if len(data) > 0 {
+			p.handleRaw(data)
+		}
+		if err != nil {
+			p.terminate(err)
+			return
+		}
+	}
+}
 
-			// parse result
-			if err := json.Unmarshal(*inc.Result, call.Reply); err != nil {
-				log.Fatal(err)
-			}
+// terminate is run once the peer's stream has ended (on EOF, a read error,
+// or an explicit Close), and fails every still-pending call with err so no
+// caller is left blocked forever. It follows the shutdown pattern of
+// net/rpc's Client.input.
+func (p *Peer) terminate(err error) {
+	p.mutex.Lock()
+	if p.closing || p.shutdown {
+		err = ErrShutdown
+	}
+	p.shutdown = true
+	pending := p.pending
+	p.pending = make(map[uint64]*Call)
+	p.mutex.Unlock()
 
-			call.done()
-		} else { // received a notification
-			p.handlerMutex.Lock()
-			handler, ok := p.handler[inc.Method]
-			p.handlerMutex.Unlock()
+	for _, call := range pending {
+		call.Error = err
+		if call.frames != nil {
+			call.frames.close()
+		}
+		call.done()
+	}
+}
+
+// Close shuts the peer down: pending and subsequent calls fail with
+// ErrShutdown. It terminates any pending calls itself rather than relying on
+// the read loop to notice, since not every Stream's underlying reader
+// unblocks promptly (or at all) once closed; it also closes the underlying
+// stream, if it implements io.Closer, so the read loop's next Read returns
+// and run stops. It is safe to call more than once.
+func (p *Peer) Close() error {
+	p.mutex.Lock()
+	if p.closing {
+		p.mutex.Unlock()
+		return ErrShutdown
+	}
+	p.closing = true
+	p.mutex.Unlock()
 
-			if !ok {
-				log.Printf("rpc: dropping notfication because there is no handler for %s registered", inc.Method)
-				continue
+	p.terminate(ErrShutdown)
+
+	if closer, ok := p.stream.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// handleRaw dispatches a single top-level JSON value, which is either a
+// JSON-RPC 2.0 batch (a JSON array of messages) or a single message. Requests
+// write their own response once their handler completes (see
+// handleRequestOrNotification); for a batch, those responses are collected
+// and written together as a single response array, per spec, once every
+// request in the batch has replied.
+func (p *Peer) handleRaw(raw []byte) {
+	if len(raw) > 0 && raw[0] == '[' {
+		var msgs []message
+		if err := json.Unmarshal(raw, &msgs); err != nil {
+			log.Println("rpc: dropping malformed batch:", err)
+			return
+		}
+		if len(msgs) == 0 {
+			p.write(&message{
+				JSONRPC: jsonrpcVersion,
+				Error:   &RPCError{Code: ErrCodeInvalidRequest, Message: "empty batch"},
+			})
+			return
+		}
+
+		var (
+			wg        sync.WaitGroup
+			batchMu   sync.Mutex
+			responses []*message
+		)
+		respond := func(resp *message) {
+			batchMu.Lock()
+			responses = append(responses, resp)
+			batchMu.Unlock()
+			wg.Done()
+		}
+
+		for i := range msgs {
+			if msgs[i].isRequest() {
+				wg.Add(1)
 			}
+			p.handleMessage(&msgs[i], respond)
+		}
+
+		wg.Wait()
+		switch len(responses) {
+		case 0:
+			// all requests were notifications; nothing to reply with
+		default:
+			// per spec, a batch is always answered with an Array, even
+			// one containing a single response
+			p.write(responses)
+		}
+		return
+	}
+
+	var m message
+	if err := json.Unmarshal(raw, &m); err != nil {
+		log.Println("rpc: dropping malformed message:", err)
+		return
+	}
+	p.handleMessage(&m, func(resp *message) { p.write(resp) })
+}
+
+// handleMessage processes a single request, notification, or response. For
+// a request, respond is called exactly once with its response, whether it
+// came from a batch (where responses are collected) or not (where respond
+// is just p.write).
+func (p *Peer) handleMessage(m *message, respond func(*message)) {
+	switch {
+	case m.isResponse():
+		p.handleResponse(m)
+	case m.isNotification() && m.Method == p.cancelMethod():
+		p.handleCancel(m)
+	default:
+		p.handleRequestOrNotification(m, respond)
+	}
+}
+
+// handleCancel looks up the in-flight request named by a cancel
+// notification's "id" param and cancels its context, if still running.
+func (p *Peer) handleCancel(m *message) {
+	var params struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if err := json.Unmarshal(m.Params, &params); err != nil {
+		log.Println("rpc: dropping malformed cancel notification:", err)
+		return
+	}
+
+	p.inflightMutex.Lock()
+	cancel, ok := p.inflight[string(params.ID)]
+	p.inflightMutex.Unlock()
+
+	if ok {
+		cancel()
+	}
+}
+
+func (p *Peer) handleResponse(m *message) {
+	var id uint64
+	if err := json.Unmarshal(m.ID, &id); err != nil {
+		log.Println("rpc: dropping response with malformed id:", err)
+		return
+	}
+
+	p.mutex.Lock()
+	call := p.pending[id]
+	p.mutex.Unlock()
+
+	if call == nil {
+		log.Println("rpc: dropping response that does not have a corresponding pending request")
+		return
+	}
+
+	if call.stream != nil {
+		p.handleStreamFrame(id, call, m)
+		return
+	}
+
+	p.mutex.Lock()
+	delete(p.pending, id)
+	p.mutex.Unlock()
+
+	switch {
+	case m.Error != nil:
+		call.Error = m.Error
+	case call.Reply != nil:
+		if err := json.Unmarshal(m.Result, call.Reply); err != nil {
+			call.Error = err
+		}
+	}
+
+	call.done()
+}
+
+// handleRequestOrNotification dispatches to the registered handler for
+// m.Method, if any. It returns as soon as the message is queued, running the
+// handler and (for requests) writing its response asynchronously; see
+// runHandler. Requests and notifications share the same claimTurn/capacity
+// machinery, so HandleSerial and Capacity bound and order both alike —
+// notably Xi's per-view "update" notifications, which is the scenario
+// HandleSerial exists for.
+func (p *Peer) handleRequestOrNotification(m *message, respond func(*message)) {
+	p.handlerMutex.Lock()
+	entry, ok := p.handler[m.Method]
+	p.handlerMutex.Unlock()
+
+	if !ok {
+		if m.isNotification() {
+			log.Printf("rpc: dropping notification because there is no handler for %s registered", m.Method)
+			return
+		}
+		respond(&message{
+			JSONRPC: jsonrpcVersion,
+			ID:      m.ID,
+			Error:   &RPCError{Code: ErrCodeMethodNotFound, Message: fmt.Sprintf("method not found: %s", m.Method)},
+		})
+		return
+	}
 
-			// TODO: allow handlers to return errors (using an error channel)?
-			go handler(inc.Params)
+	if overloaded := p.acquireSlot(); overloaded {
+		if m.isNotification() {
+			log.Printf("rpc: dropping notification for %s: too many in-flight requests", m.Method)
+			return
 		}
+		respond(&message{
+			JSONRPC: jsonrpcVersion,
+			ID:      m.ID,
+			Error:   &RPCError{Code: ErrCodeServerOverloaded, Message: "too many in-flight requests"},
+		})
+		return
 	}
 
-	if err := sc.Err(); err != nil {
-		log.Fatal(err)
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	var key string
+	if m.isRequest() {
+		// Register the cancel func before this request waits for its turn,
+		// so a cancel notification that arrives while it is still queued
+		// (behind a slow serial handler or a capacity limit) isn't missed.
+		key = string(m.ID)
+		ctx, cancel = context.WithCancel(context.Background())
+		p.inflightMutex.Lock()
+		p.inflight[key] = cancel
+		p.inflightMutex.Unlock()
+	}
+
+	// Claim this message's place in line before handing it off, so that
+	// ordering reflects the order messages were read in, not the order
+	// their goroutines happen to be scheduled.
+	waitFor, next := p.claimTurn()
+	go p.runHandler(ctx, cancel, key, m, entry, waitFor, next, respond)
+}
+
+// runHandler waits for its turn (per claimTurn), runs entry's handler, and,
+// for requests, passes its response to respond (p.write outside a batch,
+// or a collecting callback that assembles the batch's response array once
+// every request has replied). Parallel handlers release the next message's
+// turn as soon as they start; serial handlers only release it once they are
+// done (after replying, for requests), so no later message starts running
+// until this one has fully finished.
+func (p *Peer) runHandler(ctx context.Context, cancel context.CancelFunc, key string, m *message, entry handlerEntry, waitFor, next chan struct{}, respond func(*message)) {
+	defer p.releaseSlot()
+	if key != "" {
+		defer func() {
+			p.inflightMutex.Lock()
+			delete(p.inflight, key)
+			p.inflightMutex.Unlock()
+			cancel()
+		}()
+	}
+
+	<-waitFor
+	if !entry.serial {
+		close(next)
+	}
+
+	result, err := entry.fn(ctx, m.Params)
+
+	if m.isNotification() {
+		if entry.serial {
+			close(next)
+		}
+		return
+	}
+
+	resp := &message{JSONRPC: jsonrpcVersion, ID: m.ID}
+	switch {
+	case err != nil:
+		if rpcErr, ok := err.(*RPCError); ok {
+			resp.Error = rpcErr
+		} else {
+			resp.Error = &RPCError{Code: ErrCodeInternalError, Message: err.Error()}
+		}
+	default:
+		raw, merr := json.Marshal(result)
+		if merr != nil {
+			resp.Error = &RPCError{Code: ErrCodeInternalError, Message: merr.Error()}
+		} else {
+			resp.Result = raw
+		}
+	}
+
+	respond(resp)
+	if entry.serial {
+		close(next)
+	}
+}
+
+// claimTurn must be called synchronously, in the order requests are read off
+// the stream, so that the FIFO order of the resulting wait chain matches
+// arrival order even though the requests themselves run in goroutines. It
+// returns the token to wait on before running and the token the next
+// request will wait on.
+func (p *Peer) claimTurn() (waitFor, next chan struct{}) {
+	p.dispatchMutex.Lock()
+	defer p.dispatchMutex.Unlock()
+
+	waitFor = p.nextRequest
+	next = make(chan struct{})
+	p.nextRequest = next
+	return waitFor, next
+}
+
+// acquireSlot reserves one of Capacity in-flight request slots, blocking
+// until one is free unless RejectIfOverloaded is set, in which case it
+// reports overload immediately instead. A zero Capacity leaves requests
+// unbounded.
+func (p *Peer) acquireSlot() (overloaded bool) {
+	if p.Capacity <= 0 {
+		return false
+	}
+
+	p.semOnce.Do(func() { p.sem = make(chan struct{}, p.Capacity) })
+
+	if p.RejectIfOverloaded {
+		select {
+		case p.sem <- struct{}{}:
+			return false
+		default:
+			return true
+		}
+	}
+
+	p.sem <- struct{}{}
+	return false
+}
+
+func (p *Peer) releaseSlot() {
+	if p.Capacity <= 0 {
+		return
+	}
+	<-p.sem
+}
+
+func (p *Peer) write(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Println("rpc: failed encoding message:", err)
+		return
+	}
+
+	p.writeMutex.Lock()
+	defer p.writeMutex.Unlock()
+	if err := p.stream.Write(context.Background(), data); err != nil {
+		log.Println("rpc: failed writing message:", err)
 	}
 }
 
 func (p *Peer) send(call *Call) {
-	p.reqMutex.Lock()
-	defer p.reqMutex.Unlock()
+	msg, ok := p.prepareCall(call)
+	if !ok {
+		return
+	}
+	p.write(msg)
+}
+
+// CallBatch sends several calls together as a single JSON-RPC 2.0 batch
+// request, rather than one message per call, so a caller that knows it
+// needs several results up front can save the round trips Call would cost
+// it one at a time. Each call's Done/Reply is otherwise handled exactly as
+// if it had been sent individually with Call.
+func (p *Peer) CallBatch(calls ...*Call) {
+	msgs := make([]*message, 0, len(calls))
+	for _, call := range calls {
+		msg, ok := p.prepareCall(call)
+		if !ok {
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+	if len(msgs) == 0 {
+		return
+	}
+	p.write(msgs)
+}
 
-	// add call to pending
+// prepareCall registers call in p.pending and builds its outbound message.
+// It reports false, after failing call with ErrShutdown or the marshaling
+// error, if the call could not be prepared.
+func (p *Peer) prepareCall(call *Call) (*message, bool) {
 	p.mutex.Lock()
+	if p.closing || p.shutdown {
+		p.mutex.Unlock()
+		call.Error = ErrShutdown
+		if call.frames != nil {
+			call.frames.close()
+		}
+		call.done()
+		return nil, false
+	}
+
 	// start at 1, because IDs with value of 0 will be omitted to allow
 	// rpc notifications (instead of requests)
 	p.seq++
@@ -115,16 +617,36 @@ func (p *Peer) send(call *Call) {
 	p.pending[call.ID] = call
 	p.mutex.Unlock()
 
-	// encode and send
-	err := p.out.Encode(call)
+	params, err := json.Marshal(call.Params)
 	if err != nil {
-		p.mutex.Lock()
-		delete(p.pending, call.ID)
-		p.mutex.Unlock()
+		p.failCall(call, err)
+		return nil, false
+	}
 
-		call.Error = err
-		call.done()
+	id, err := json.Marshal(call.ID)
+	if err != nil {
+		p.failCall(call, err)
+		return nil, false
+	}
+
+	return &message{
+		JSONRPC: jsonrpcVersion,
+		ID:      id,
+		Method:  call.Method,
+		Params:  params,
+	}, true
+}
+
+func (p *Peer) failCall(call *Call, err error) {
+	p.mutex.Lock()
+	delete(p.pending, call.ID)
+	p.mutex.Unlock()
+
+	call.Error = err
+	if call.frames != nil {
+		call.frames.close()
 	}
+	call.done()
 }
 
 func (call *Call) done() {
@@ -136,9 +658,17 @@ func (call *Call) done() {
 	}
 }
 
-func (p *Peer) Handle(method string, handler Handler) {
+// Handle registers handler for method. By default, requests for method run
+// in parallel with no ordering guarantee; pass HandleSerial() to require
+// that they instead run one at a time, in the order they were received.
+func (p *Peer) Handle(method string, handler Handler, opts ...HandleOption) {
+	entry := handlerEntry{fn: handler}
+	for _, opt := range opts {
+		opt(&entry)
+	}
+
 	p.handlerMutex.Lock()
-	p.handler[method] = handler
+	p.handler[method] = entry
 	p.handlerMutex.Unlock()
 }
 
@@ -147,6 +677,56 @@ func (p *Peer) CallSync(method string, params interface{}, reply interface{}) er
 	return call.Error
 }
 
+// CallContext behaves like CallSync, but fails with ctx.Err() and emits a
+// cancel notification to the peer if ctx is done before a reply arrives,
+// instead of leaving the call pending forever.
+func (p *Peer) CallContext(ctx context.Context, method string, params, reply interface{}) error {
+	call := p.Call(method, params, reply, make(chan *Call, 1))
+
+	select {
+	case <-ctx.Done():
+		p.cancel(call, ctx.Err())
+		return ctx.Err()
+	case call := <-call.Done:
+		return call.Error
+	}
+}
+
+// cancel aborts a still-pending call with err and notifies the peer so it
+// can stop working on it. It is a no-op if the call already completed.
+func (p *Peer) cancel(call *Call, err error) {
+	p.mutex.Lock()
+	_, pending := p.pending[call.ID]
+	delete(p.pending, call.ID)
+	p.mutex.Unlock()
+
+	if !pending {
+		return
+	}
+
+	call.Error = err
+	if call.frames != nil {
+		call.frames.close()
+	}
+
+	id, merr := json.Marshal(call.ID)
+	if merr != nil {
+		return
+	}
+	params, merr := json.Marshal(struct {
+		ID json.RawMessage `json:"id"`
+	}{ID: id})
+	if merr != nil {
+		return
+	}
+
+	p.write(&message{
+		JSONRPC: jsonrpcVersion,
+		Method:  p.cancelMethod(),
+		Params:  params,
+	})
+}
+
 func (p *Peer) Call(method string, params interface{}, reply interface{}, done chan *Call) *Call {
 	if done == nil {
 		done = make(chan *Call, 10)
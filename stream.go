@@ -0,0 +1,137 @@
+package peer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Stream abstracts the framing and transport used to exchange messages with
+// a peer, so that Peer isn't tied to any particular connection (a stdio
+// child process, a TCP socket, ...), analogous to x/tools/internal/jsonrpc2's
+// Stream.
+type Stream interface {
+	// Read returns the next complete, framed message.
+	Read(ctx context.Context) ([]byte, error)
+	// Write sends a single complete, already-encoded message.
+	Write(ctx context.Context, data []byte) error
+}
+
+// NewlineStream frames messages as newline-delimited values, read with a
+// growable buffer rather than bufio.Scanner's fixed 64KiB line limit.
+type NewlineStream struct {
+	raw io.Reader
+	r   *bufio.Reader
+	w   io.Writer
+
+	writeMutex sync.Mutex // protects w
+}
+
+// NewNewlineStream creates a NewlineStream reading from r and writing to w.
+func NewNewlineStream(r io.Reader, w io.Writer) *NewlineStream {
+	return &NewlineStream{raw: r, r: bufio.NewReader(r), w: w}
+}
+
+func (s *NewlineStream) Read(ctx context.Context) ([]byte, error) {
+	line, err := s.r.ReadBytes('\n')
+	return bytes.TrimRight(line, "\r\n"), err
+}
+
+func (s *NewlineStream) Write(ctx context.Context, data []byte) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	if _, err := s.w.Write(data); err != nil {
+		return err
+	}
+	_, err := s.w.Write([]byte{'\n'})
+	return err
+}
+
+// Close closes the underlying reader, if it implements io.Closer, unblocking
+// any in-progress Read so that Peer.run can observe the stream ending.
+func (s *NewlineStream) Close() error {
+	if c, ok := s.raw.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// contentLengthHeader is the LSP-style framing header naming the byte
+// length of the message body that follows the blank line terminating it.
+const contentLengthHeader = "content-length:"
+
+// HeaderStream frames messages with LSP-style "Content-Length:" headers,
+// for use over connections, such as TCP or pipes, where newline-delimiting
+// isn't appropriate (e.g. the payload itself may contain newlines).
+type HeaderStream struct {
+	raw io.Reader
+	r   *bufio.Reader
+	w   io.Writer
+
+	writeMutex sync.Mutex // protects w
+}
+
+// NewHeaderStream creates a HeaderStream reading from r and writing to w.
+func NewHeaderStream(r io.Reader, w io.Writer) *HeaderStream {
+	return &HeaderStream{raw: r, r: bufio.NewReader(r), w: w}
+}
+
+func (s *HeaderStream) Read(ctx context.Context) ([]byte, error) {
+	var length int
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+
+		if strings.HasPrefix(strings.ToLower(line), contentLengthHeader) {
+			v := strings.TrimSpace(line[len(contentLengthHeader):])
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("peer: malformed Content-Length header: %w", err)
+			}
+			length = n
+		}
+	}
+
+	if length <= 0 {
+		return nil, fmt.Errorf("peer: message is missing a valid Content-Length header")
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(s.r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s *HeaderStream) Write(ctx context.Context, data []byte) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	if _, err := fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n", len(data)); err != nil {
+		return err
+	}
+	_, err := s.w.Write(data)
+	return err
+}
+
+// Close closes the underlying reader, if it implements io.Closer, unblocking
+// any in-progress Read so that Peer.run can observe the stream ending.
+func (s *HeaderStream) Close() error {
+	if c, ok := s.raw.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package peer
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// eosResult is the sentinel Xi sends as a subscription's terminal "result"
+// to signal that no further frames will follow.
+const eosResult = `"EOS"`
+
+// Subscription represents a long-lived, multi-response call, as used by Xi
+// methods that stream multiple results for a single request (e.g.
+// find_all, plugin events) instead of replying once.
+type Subscription struct {
+	// C delivers the raw "result" of every frame the peer sends for this
+	// subscription, in order. It is closed once the peer ends the stream,
+	// sends an error, or Close is called.
+	C <-chan json.RawMessage
+
+	peer *Peer
+	call *Call
+}
+
+// Subscribe sends method/params like Call, but keeps the request open:
+// every subsequent response frame sharing its id is delivered on
+// Subscription.C instead of completing the call after the first one.
+func (p *Peer) Subscribe(method string, params interface{}) *Subscription {
+	stream := make(chan json.RawMessage, 16)
+	call := &Call{
+		Method: method,
+		Params: params,
+		Done:   make(chan *Call, 1),
+		stream: stream,
+		frames: newFrameQueue(),
+	}
+	p.send(call)
+	go deliverFrames(call.frames, stream)
+	return &Subscription{C: stream, peer: p, call: call}
+}
+
+// Close ends the subscription: it sends a cancel notification for the
+// original request and removes it from the pending set, so no frames
+// received after it are delivered. Frames already queued are still sent on
+// C before it is closed.
+func (s *Subscription) Close() error {
+	s.peer.cancel(s.call, ErrSubscriptionClosed)
+	return nil
+}
+
+// handleStreamFrame decodes a single response frame belonging to an
+// in-flight subscription, in the shared read loop, and queues its result
+// for delivery until the peer sends an error or the eosResult marker.
+// Queuing never blocks the read loop on a slow subscriber; the frame's
+// actual delivery to Subscription.C happens on the queue's own
+// deliverFrames goroutine, which is also the sole owner of call.stream's
+// lifetime (see frameQueue).
+func (p *Peer) handleStreamFrame(id uint64, call *Call, m *message) {
+	if m.Error == nil && string(m.Result) != eosResult {
+		call.frames.push(m.Result)
+		return
+	}
+
+	p.mutex.Lock()
+	_, ok := p.pending[id]
+	delete(p.pending, id)
+	p.mutex.Unlock()
+
+	if !ok {
+		return
+	}
+
+	if m.Error != nil {
+		call.Error = m.Error
+	}
+	call.frames.close()
+	call.done()
+}
+
+// frameQueue is an unbounded, ordered queue of subscription frames, used to
+// hand frames from the shared read loop to a subscription's own
+// deliverFrames goroutine. Pushing is always non-blocking, so a slow or
+// stalled subscriber never stalls the read loop or any other in-flight
+// call; only the subscription's own delivery goroutine blocks on the
+// subscriber.
+type frameQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	items  []json.RawMessage
+	closed bool
+}
+
+func newFrameQueue() *frameQueue {
+	q := &frameQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push appends item to the queue, unless the queue has already been closed.
+func (q *frameQueue) push(item json.RawMessage) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.items = append(q.items, item)
+	q.cond.Signal()
+}
+
+// close marks the queue closed: items already pushed are still delivered by
+// pop, but no further pushes are accepted. It is safe to call more than
+// once.
+func (q *frameQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	q.cond.Signal()
+}
+
+// pop blocks until an item is available or the queue is closed and
+// drained, in which case ok is false.
+func (q *frameQueue) pop() (item json.RawMessage, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.items) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.items) == 0 {
+		return nil, false
+	}
+	item, q.items = q.items[0], q.items[1:]
+	return item, true
+}
+
+// deliverFrames forwards every frame pushed to queue onto stream, in order,
+// closing stream once the queue is closed and fully drained. It is the sole
+// owner of stream's lifetime, so a subscriber that stops reading only ever
+// blocks this goroutine, never the shared read loop, and stream is never
+// closed concurrently with a send into it.
+func deliverFrames(queue *frameQueue, stream chan<- json.RawMessage) {
+	defer close(stream)
+	for {
+		frame, ok := queue.pop()
+		if !ok {
+			return
+		}
+		stream <- frame
+	}
+}